@@ -3,16 +3,21 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,6 +26,9 @@ import (
 	"time"
 )
 
+// probeSampleDuration 是 -probe 模式下对每个 URL 做吞吐量采样的时长
+const probeSampleDuration = 3 * time.Second
+
 // ============================================================================
 // DownTraffic - Linux 下载流量消耗工具
 // 通过并发下载公共文件并丢弃数据来消耗下载带宽，磁盘零占用。
@@ -68,6 +76,43 @@ func (cr *countingReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// ewma 实现一个简单的指数加权移动平均，用于平滑瞬时速率，
+// 避免 TCP 慢启动 / 突发流量造成的显示抖动
+type ewma struct {
+	alpha  float64
+	value  float64
+	primed bool
+}
+
+func newEWMA(alpha float64) *ewma {
+	return &ewma{alpha: alpha}
+}
+
+// update 输入一个新样本，返回平滑后的当前值
+func (e *ewma) update(sample float64) float64 {
+	if !e.primed {
+		e.value = sample
+		e.primed = true
+	} else {
+		e.value = e.alpha*sample + (1-e.alpha)*e.value
+	}
+	return e.value
+}
+
+// smoothedRate 在多个 goroutine 间无锁共享一个 float64 速率值
+// (statsReporter 写入，autoscaleLoop 读取)
+type smoothedRate struct {
+	bits int64
+}
+
+func (s *smoothedRate) store(v float64) {
+	atomic.StoreInt64(&s.bits, int64(math.Float64bits(v)))
+}
+
+func (s *smoothedRate) load() float64 {
+	return math.Float64frombits(uint64(atomic.LoadInt64(&s.bits)))
+}
+
 // formatBytes 将字节数格式化为人类可读的字符串
 func formatBytes(bytes int64) string {
 	const (
@@ -148,6 +193,13 @@ func parseSize(s string) (int64, error) {
 	return int64(val * float64(multiplier)), nil
 }
 
+// parseRate 解析速率字符串（如 500M/s, 1G/s），复用 parseSize 的单位换算
+func parseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(s, "/s")
+	return parseSize(s)
+}
+
 // ============================================================================
 // 网卡流量读取（对等模式核心）
 // ============================================================================
@@ -250,19 +302,622 @@ func loadURLs(path string) []string {
 	return urls
 }
 
-// worker 是下载工作协程，从 urlCh 获取 URL 进行下载
-func worker(ctx context.Context, id int, urls []string, counter *int64, wg *sync.WaitGroup) {
-	defer wg.Done()
+// ============================================================================
+// 预检测 (-probe)：下载开始前对 URL 做一次基准测试，按测得吞吐量加权选择
+// ============================================================================
+
+// urlStat 记录一个 URL 的一次探测结果
+type urlStat struct {
+	url       string
+	connectMs int64
+	ttfbMs    int64
+	speedBps  float64
+}
+
+// probeURL 对单个 URL 测量连接延迟、TTFB，并做一次限时吞吐量采样
+func probeURL(parent context.Context, client *http.Client, url string, sampleDur time.Duration) (*urlStat, error) {
+	ctx, cancel := context.WithTimeout(parent, sampleDur+5*time.Second)
+	defer cancel()
+
+	var connectStart, connectDone, firstByte time.Time
+	trace := &httptrace.ClientTrace{
+		ConnectStart:         func(network, addr string) { connectStart = time.Now() },
+		ConnectDone:          func(network, addr string, err error) { connectDone = time.Now() },
+		GotFirstResponseByte: func() { firstByte = time.Now() },
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	connectMs := int64(0)
+	if !connectStart.IsZero() && !connectDone.IsZero() {
+		connectMs = connectDone.Sub(connectStart).Milliseconds()
+	}
+	ttfbMs := time.Since(start).Milliseconds()
+	if !firstByte.IsZero() {
+		ttfbMs = firstByte.Sub(start).Milliseconds()
+	}
+
+	// 限时吞吐采样：最多读 256MB，以先到的超时或字节上限为准
+	const sampleCap = 256 * 1024 * 1024
+	var sampled int64
+	cr := &countingReader{reader: resp.Body, counter: &sampled}
+
+	sampleCtx, sampleCancel := context.WithTimeout(ctx, sampleDur)
+	defer sampleCancel()
+
+	sampleStart := time.Now()
+	done := make(chan struct{})
+	go func() {
+		io.CopyN(io.Discard, cr, sampleCap)
+		close(done)
+	}()
+	select {
+	case <-sampleCtx.Done():
+	case <-done:
+	}
+	elapsed := time.Since(sampleStart)
+
+	speed := float64(0)
+	if elapsed.Seconds() > 0 {
+		speed = float64(atomic.LoadInt64(&sampled)) / elapsed.Seconds()
+	}
+
+	return &urlStat{url: url, connectMs: connectMs, ttfbMs: ttfbMs, speedBps: speed}, nil
+}
+
+// benchmarkURLs 并发探测每个 URL，过滤掉失败或低于 minSpeedBps 的条目，
+// 按测得吞吐量从高到低排序后返回
+func benchmarkURLs(ctx context.Context, urls []string, minSpeedBps int64, sampleDur time.Duration) []urlStat {
+	client := &http.Client{Timeout: 0, Transport: &http.Transport{DisableCompression: true}}
+
+	var mu sync.Mutex
+	var stats []urlStat
+	var wg sync.WaitGroup
 
-	client := &http.Client{
+	for _, u := range urls {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			stat, err := probeURL(ctx, client, u, sampleDur)
+			if err != nil {
+				log.Printf("  [探测] ✗ %s: %v", truncateURL(u), err)
+				return
+			}
+			mu.Lock()
+			stats = append(stats, *stat)
+			mu.Unlock()
+		}(u)
+	}
+	wg.Wait()
+
+	filtered := stats[:0]
+	for _, s := range stats {
+		if minSpeedBps > 0 && int64(s.speedBps) < minSpeedBps {
+			log.Printf("  [探测] ⚠ %s 低于最低速度阈值 (%s < %s)，已剔除",
+				truncateURL(s.url), formatSpeed(int64(s.speedBps)), formatSpeed(minSpeedBps))
+			continue
+		}
+		filtered = append(filtered, s)
+	}
+
+	sort.Slice(filtered, func(i, j int) bool { return filtered[i].speedBps > filtered[j].speedBps })
+	return filtered
+}
+
+// printProbeTable 打印探测结果排行榜
+func printProbeTable(stats []urlStat) {
+	fmt.Println()
+	fmt.Println("  探测结果 (按吞吐量排序):")
+	fmt.Println("  ────────────────────────────────────────────────────────────────")
+	fmt.Printf("  %-4s %-8s %-8s %-12s %s\n", "#", "连接", "TTFB", "吞吐", "URL")
+	for i, s := range stats {
+		fmt.Printf("  %-4d %-8s %-8s %-12s %s\n",
+			i+1,
+			fmt.Sprintf("%dms", s.connectMs),
+			fmt.Sprintf("%dms", s.ttfbMs),
+			formatSpeed(int64(s.speedBps)),
+			truncateURL(s.url),
+		)
+	}
+	fmt.Println("  ────────────────────────────────────────────────────────────────")
+	fmt.Println()
+}
+
+// weightedURLPicker 按测得吞吐量加权随机选择 URL，吞吐越高的镜像被选中的概率越大；
+// update 可在运行中被 probeRefreshLoop 调用以热替换权重
+type weightedURLPicker struct {
+	mu      sync.RWMutex
+	urls    []string
+	weights []float64
+	total   float64
+}
+
+func newWeightedURLPicker(stats []urlStat) *weightedURLPicker {
+	p := &weightedURLPicker{}
+	p.update(stats)
+	return p
+}
+
+func (p *weightedURLPicker) update(stats []urlStat) {
+	urls := make([]string, 0, len(stats))
+	weights := make([]float64, 0, len(stats))
+	var total float64
+	for _, s := range stats {
+		w := s.speedBps
+		if w <= 0 {
+			w = 1 // 保底权重，避免被完全忽略
+		}
+		urls = append(urls, s.url)
+		weights = append(weights, w)
+		total += w
+	}
+
+	p.mu.Lock()
+	p.urls = urls
+	p.weights = weights
+	p.total = total
+	p.mu.Unlock()
+}
+
+func (p *weightedURLPicker) pick() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if len(p.urls) == 0 {
+		return ""
+	}
+	r := rand.Float64() * p.total
+	for i, w := range p.weights {
+		r -= w
+		if r <= 0 {
+			return p.urls[i]
+		}
+	}
+	return p.urls[len(p.urls)-1]
+}
+
+// probeRefreshLoop 定期重新探测 URL 列表并更新加权选择器的权重，
+// 让吞吐量下降的镜像被自动降权
+func probeRefreshLoop(ctx context.Context, urls []string, minSpeedBps int64, sampleDur time.Duration, picker *weightedURLPicker) {
+	const refreshInterval = 10 * time.Minute
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			log.Printf("🔄 重新探测 URL 列表...")
+			stats := benchmarkURLs(ctx, urls, minSpeedBps, sampleDur)
+			if len(stats) == 0 {
+				log.Printf("⚠ 重新探测后没有可用 URL，保留旧权重")
+				continue
+			}
+			picker.update(stats)
+			printProbeTable(stats)
+		}
+	}
+}
+
+// ============================================================================
+// HTTPing 式健康检查 + CDN POP 过滤 (-colo)
+// ============================================================================
+
+// httpingResult 记录一次 HTTPing 式探测结果
+type httpingResult struct {
+	url    string
+	status int
+	rttMs  int64
+	colo   string // 解析自 CF-Ray 的服务 POP 代码，非 Cloudflare 节点为空
+	server string
+}
+
+// httping 对 URL 发起一次 Range: bytes=0-0 的小 GET，只看响应头不下载正文，
+// 记录状态码、RTT，并尝试从 CF-Ray 响应头解析出服务该请求的 CDN POP
+func httping(ctx context.Context, client *http.Client, url string, timeout time.Duration) (*httpingResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Range", "bytes=0-0")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	rtt := time.Since(start)
+	defer resp.Body.Close()
+
+	return &httpingResult{
+		url:    url,
+		status: resp.StatusCode,
+		rttMs:  rtt.Milliseconds(),
+		colo:   extractColo(resp.Header),
+		server: resp.Header.Get("Server"),
+	}, nil
+}
+
+// extractColo 从 CDN 响应头中解析服务该请求的 POP/colo 代码。
+// Cloudflare 的 CF-Ray 形如 "814b1e9bcae2e1c4-LAX"，取最后一个 "-" 之后的
+// 部分再截取末 3 位字母得到机场代码；只解析 CF-Ray，Server 头仅在 httping
+// 中用于展示，cf-cache-status 不被读取
+func extractColo(header http.Header) string {
+	ray := header.Get("CF-RAY")
+	if ray == "" {
+		return ""
+	}
+	idx := strings.LastIndex(ray, "-")
+	if idx == -1 || idx+1 >= len(ray) {
+		return ""
+	}
+	colo := ray[idx+1:]
+	if len(colo) > 3 {
+		colo = colo[len(colo)-3:]
+	}
+	return strings.ToUpper(colo)
+}
+
+// httpingSweep 并发对所有 URL 做一次 HTTPing 探测，按 RTT 从低到高排序
+func httpingSweep(ctx context.Context, urls []string, timeout time.Duration) []httpingResult {
+	client := &http.Client{Timeout: 0, Transport: &http.Transport{DisableCompression: true}}
+
+	var mu sync.Mutex
+	var results []httpingResult
+	var wg sync.WaitGroup
+
+	for _, u := range urls {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			r, err := httping(ctx, client, u, timeout)
+			if err != nil {
+				log.Printf("  [HTTPing] ✗ %s: %v", truncateURL(u), err)
+				return
+			}
+			mu.Lock()
+			results = append(results, *r)
+			mu.Unlock()
+		}(u)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].rttMs < results[j].rttMs })
+	return results
+}
+
+// filterByColo 仅保留 colo 命中 allowed 集合的探测结果；allowed 为空时原样返回
+func filterByColo(results []httpingResult, allowed map[string]bool) []httpingResult {
+	if len(allowed) == 0 {
+		return results
+	}
+	filtered := results[:0]
+	for _, r := range results {
+		if allowed[r.colo] {
+			filtered = append(filtered, r)
+		} else {
+			log.Printf("  [HTTPing] ⚠ %s 服务 POP 为 %q，不在 -colo 允许列表中，已剔除", truncateURL(r.url), r.colo)
+		}
+	}
+	return filtered
+}
+
+// printHTTPingTable 打印 HTTPing 探测结果
+func printHTTPingTable(results []httpingResult) {
+	fmt.Println()
+	fmt.Println("  HTTPing 探测结果:")
+	fmt.Println("  ────────────────────────────────────────────────────────────────")
+	fmt.Printf("  %-4s %-6s %-8s %-6s %-16s %s\n", "#", "状态", "RTT", "POP", "Server", "URL")
+	for i, r := range results {
+		colo := r.colo
+		if colo == "" {
+			colo = "-"
+		}
+		server := r.server
+		if server == "" {
+			server = "-"
+		}
+		fmt.Printf("  %-4d %-6d %-8s %-6s %-16s %s\n", i+1, r.status, fmt.Sprintf("%dms", r.rttMs), colo, server, truncateURL(r.url))
+	}
+	fmt.Println("  ────────────────────────────────────────────────────────────────")
+	fmt.Println()
+}
+
+// ============================================================================
+// 会话状态持久化 (-resume) 与本地控制端点 (-ctrl)
+// ============================================================================
+
+// sessionState 是周期性落盘的下载进度快照，-resume 启动时据此续传
+type sessionState struct {
+	StartTime       time.Time `json:"start_time"`
+	TotalBytes      int64     `json:"total_bytes"`
+	IfaceRxBaseline int64     `json:"iface_rx_baseline"`
+	IfaceTxBaseline int64     `json:"iface_tx_baseline"`
+	LimitBytes      int64     `json:"limit_bytes"`
+	OffsetBytes     int64     `json:"offset_bytes"`
+}
+
+// defaultSessionPath 返回 ~/.downtraffic/session.json
+func defaultSessionPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return filepath.Join(home, ".downtraffic", "session.json")
+}
+
+// loadSession 从磁盘读取上一次的会话快照
+func loadSession(path string) (*sessionState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s sessionState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// saveSession 将当前进度写入磁盘，先写临时文件再原子替换，避免进程被杀
+// 导致的半写坏文件
+func saveSession(path string, s *sessionState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// resumeBalanceOffset 结合上次落盘的网卡基线，推算对等模式续传时需要叠加的
+// 额外偏移量。正常情况下 (进程被杀后重启，网卡计数器未归零) 不需要任何调整，
+// 之前的下载进度已经体现在当前的 RxBytes 里；只有网卡计数器发生过归零
+// (如机器重启) 时，才需要把归零前的那部分差值补偿回来
+func resumeBalanceOffset(prev *sessionState, current *netStats) int64 {
+	var extra int64
+	if current.TxBytes < prev.IfaceTxBaseline {
+		extra += prev.IfaceTxBaseline - current.TxBytes
+	}
+	if current.RxBytes < prev.IfaceRxBaseline {
+		extra -= prev.IfaceRxBaseline - current.RxBytes
+	}
+	return extra
+}
+
+// sessionCheckpointLoop 每隔 checkpointInterval 把当前进度写入磁盘
+func sessionCheckpointLoop(ctx context.Context, path string, counter *int64, startTime time.Time, limitBytes, offsetBytes int64, rxBaseline, txBaseline int64) {
+	const checkpointInterval = 5 * time.Second
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s := &sessionState{
+				StartTime:       startTime,
+				TotalBytes:      atomic.LoadInt64(counter),
+				IfaceRxBaseline: rxBaseline,
+				IfaceTxBaseline: txBaseline,
+				LimitBytes:      limitBytes,
+				OffsetBytes:     offsetBytes,
+			}
+			if err := saveSession(path, s); err != nil {
+				log.Printf("⚠ 会话状态保存失败: %v", err)
+			}
+		}
+	}
+}
+
+// controlState 是下载任务的运行状态机，只有 Running 与 Paused 两态，
+// 没有单独的 Ready 或 Done 状态
+type controlState int
+
+const (
+	stateRunning controlState = iota
+	statePaused
+)
+
+func (s controlState) String() string {
+	if s == statePaused {
+		return "paused"
+	}
+	return "running"
+}
+
+// controller 是 -ctrl 控制端点背后的状态机：worker 在每轮下载前调用
+// waitIfPaused 阻塞在 Paused 状态，直到 resume() 被调用
+type controller struct {
+	mu         sync.Mutex
+	state      controlState
+	resumed    chan struct{}
+	pool       *workerPool
+	totalBytes *int64
+	startTime  time.Time
+}
+
+func newController(pool *workerPool, totalBytes *int64, startTime time.Time) *controller {
+	return &controller{state: stateRunning, resumed: make(chan struct{}), pool: pool, totalBytes: totalBytes, startTime: startTime}
+}
+
+func (c *controller) pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = statePaused
+}
+
+func (c *controller) resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == statePaused {
+		c.state = stateRunning
+		close(c.resumed)
+		c.resumed = make(chan struct{})
+	}
+}
+
+// waitIfPaused 在 Paused 状态下阻塞，直到恢复运行或 ctx 被取消
+func (c *controller) waitIfPaused(ctx context.Context) {
+	for {
+		c.mu.Lock()
+		if c.state != statePaused {
+			c.mu.Unlock()
+			return
+		}
+		ch := c.resumed
+		c.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *controller) status() string {
+	c.mu.Lock()
+	state := c.state
+	c.mu.Unlock()
+	return fmt.Sprintf("state=%s workers=%d total=%s elapsed=%s",
+		state, c.pool.size(), formatBytes(atomic.LoadInt64(c.totalBytes)), formatDuration(time.Since(c.startTime)))
+}
+
+func (c *controller) setWorkers(n int) error {
+	if n < 1 {
+		return fmt.Errorf("worker 数必须 >= 1")
+	}
+	for c.pool.size() < n {
+		c.pool.grow()
+	}
+	for c.pool.size() > n {
+		c.pool.shrink()
+	}
+	return nil
+}
+
+// startControlServer 监听 -ctrl 指定的地址并处理控制命令。以 ":" 开头的地址
+// 视为 TCP 端口 (如 ":7777")，否则视为 Unix socket 路径 (如 /run/downtraffic.sock)
+func startControlServer(ctx context.Context, addr string, ctrl *controller) error {
+	var ln net.Listener
+	var err error
+	if strings.HasPrefix(addr, ":") {
+		ln, err = net.Listen("tcp", addr)
+	} else {
+		os.Remove(addr) // 清理上次异常退出遗留的 socket 文件
+		ln, err = net.Listen("unix", addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return // 监听器已随 ctx 取消而关闭
+			}
+			go handleControlConn(conn, ctrl)
+		}
+	}()
+
+	log.Printf("✓ 控制端点已启动: %s (支持 pause / resume / status / set-workers N)", addr)
+	return nil
+}
+
+// handleControlConn 处理一条控制连接，每行一个命令，每个命令回复一行
+func handleControlConn(conn net.Conn, ctrl *controller) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fields := strings.Fields(strings.TrimSpace(scanner.Text()))
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "pause":
+			ctrl.pause()
+			fmt.Fprintln(conn, "OK paused")
+		case "resume":
+			ctrl.resume()
+			fmt.Fprintln(conn, "OK resumed")
+		case "status":
+			fmt.Fprintln(conn, ctrl.status())
+		case "set-workers":
+			if len(fields) != 2 {
+				fmt.Fprintln(conn, "ERR usage: set-workers N")
+				continue
+			}
+			n, err := strconv.Atoi(fields[1])
+			if err != nil {
+				fmt.Fprintln(conn, "ERR invalid worker count")
+				continue
+			}
+			if err := ctrl.setWorkers(n); err != nil {
+				fmt.Fprintf(conn, "ERR %v\n", err)
+				continue
+			}
+			fmt.Fprintln(conn, "OK workers set")
+		default:
+			fmt.Fprintln(conn, "ERR unknown command")
+		}
+	}
+}
+
+// userAgent 模拟正常浏览器的 UA，所有请求共用
+const userAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+// newDownloadClient 构造下载用的 http.Client。maxConnsPerHost 是*全局*每主机
+// 连接数上限：所有 worker 共享同一个 client/Transport，连接池由 net/http 统一
+// 管理，因此这个上限是跨 worker 生效的，而不是每个 worker 各自拥有一份配额
+func newDownloadClient(maxConnsPerHost int) *http.Client {
+	return &http.Client{
 		Timeout: 0, // 不设整体超时，通过 context 控制
 		Transport: &http.Transport{
-			MaxIdleConns:        10,
+			MaxIdleConns:        10 * maxConnsPerHost,
 			IdleConnTimeout:     90 * time.Second,
 			DisableCompression:  true, // 禁用压缩以获得更大的传输量
-			MaxIdleConnsPerHost: 2,
+			MaxIdleConnsPerHost: maxConnsPerHost,
+			MaxConnsPerHost:     maxConnsPerHost,
 		},
 	}
+}
+
+// worker 是下载工作协程，从 urls 中取下一个 URL 进行下载；client 由调用方共享
+func worker(ctx context.Context, id int, urls []string, counter *int64, wg *sync.WaitGroup, chunks int, picker *weightedURLPicker, ctrl *controller, client *http.Client) {
+	defer wg.Done()
 
 	urlIndex := rand.Intn(len(urls)) // 随机起始位置，避免所有 worker 同时下载同一文件
 
@@ -273,10 +928,26 @@ func worker(ctx context.Context, id int, urls []string, counter *int64, wg *sync
 		default:
 		}
 
-		url := urls[urlIndex%len(urls)]
-		urlIndex++
+		if ctrl != nil {
+			ctrl.waitIfPaused(ctx) // -ctrl 下发 pause 时阻塞在这里，resume 后继续
+		}
+
+		url := ""
+		if picker != nil {
+			url = picker.pick()
+		}
+		if url == "" {
+			url = urls[urlIndex%len(urls)]
+			urlIndex++
+		}
 
-		if err := download(ctx, client, url, id, counter); err != nil {
+		var err error
+		if chunks > 1 {
+			err = downloadChunked(ctx, client, url, id, counter, chunks)
+		} else {
+			err = download(ctx, client, url, id, counter)
+		}
+		if err != nil {
 			if ctx.Err() != nil {
 				return // context 已取消，正常退出
 			}
@@ -286,14 +957,105 @@ func worker(ctx context.Context, id int, urls []string, counter *int64, wg *sync
 	}
 }
 
+// workerPool 管理一组可动态增减的下载 worker，支撑 -auto 自动扩缩容模式。
+// grow 启动一个新 worker；shrink 取消最近启动的一个（其在途请求会被中断，
+// worker 的重试循环随后自然退出）
+type workerPool struct {
+	mu      sync.Mutex
+	cancels []context.CancelFunc
+	parent  context.Context
+	urls    []string
+	counter *int64
+	chunks  int
+	wg      *sync.WaitGroup
+	nextID  int
+	picker  *weightedURLPicker
+	ctrl    *controller
+	client  *http.Client
+}
+
+func newWorkerPool(parent context.Context, urls []string, counter *int64, chunks int, wg *sync.WaitGroup, picker *weightedURLPicker, client *http.Client) *workerPool {
+	return &workerPool{parent: parent, urls: urls, counter: counter, chunks: chunks, wg: wg, picker: picker, client: client}
+}
+
+func (p *workerPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.cancels)
+}
+
+func (p *workerPool) grow() {
+	p.mu.Lock()
+	p.nextID++
+	id := p.nextID
+	ctx, cancel := context.WithCancel(p.parent)
+	p.cancels = append(p.cancels, cancel)
+	p.mu.Unlock()
+
+	p.wg.Add(1)
+	go worker(ctx, id, p.urls, p.counter, p.wg, p.chunks, p.picker, p.ctrl, p.client)
+}
+
+func (p *workerPool) shrink() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.cancels) <= 1 {
+		return // 至少保留一个 worker
+	}
+	last := p.cancels[len(p.cancels)-1]
+	p.cancels = p.cancels[:len(p.cancels)-1]
+	last()
+}
+
+// autoscaleLoop 每隔 sampleInterval 采样一次 EWMA 平滑速率：相比上次采样
+// 提速达到 growThreshold 则继续加 worker；回落或原地踏步则减一个 worker；
+// 一旦 targetRate 已设置且实测速率逼近目标（5% 以内），停止扩容
+func autoscaleLoop(ctx context.Context, pool *workerPool, rate *smoothedRate, maxWorkers int, targetRate int64) {
+	const sampleInterval = 5 * time.Second
+	const growThreshold = 0.05 // 相比上次采样提速 >=5% 才继续扩容
+	const nearTarget = 0.05    // 距离目标速率 5% 以内视为已达标
+
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+
+	var lastRate float64
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := rate.load()
+
+			if targetRate > 0 && current >= float64(targetRate)*(1-nearTarget) {
+				lastRate = current
+				continue
+			}
+			if pool.size() >= maxWorkers {
+				lastRate = current
+				continue
+			}
+
+			switch {
+			case lastRate == 0 || current >= lastRate*(1+growThreshold):
+				pool.grow()
+				log.Printf("⚡ [自动扩容] 吞吐提升至 %s，worker 数 -> %d", formatSpeed(int64(current)), pool.size())
+			default:
+				pool.shrink()
+				log.Printf("⚡ [自动缩容] 吞吐回落或持平于 %s，worker 数 -> %d", formatSpeed(int64(current)), pool.size())
+			}
+			lastRate = current
+		}
+	}
+}
+
 // download 执行单次下载
 func download(ctx context.Context, client *http.Client, url string, workerID int, counter *int64) error {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return err
 	}
-	// 设置 User-Agent 模拟正常浏览器
-	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	req.Header.Set("User-Agent", userAgent)
 
 	log.Printf("  [W%d] ↓ 开始下载: %s", workerID, truncateURL(url))
 
@@ -318,6 +1080,136 @@ func download(ctx context.Context, client *http.Client, url string, workerID int
 	return err
 }
 
+// probeRange 探测资源是否支持 Range 请求及其总大小，优先用 HEAD，
+// 部分服务器对 HEAD 支持不佳时退化为 Range: bytes=0-0 的 GET
+func probeRange(ctx context.Context, client *http.Client, url string) (contentLength int64, acceptRanges bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return resp.ContentLength, resp.Header.Get("Accept-Ranges") == "bytes", nil
+		}
+	}
+
+	// HEAD 失败或被拒绝，退化为 Range: bytes=0-0 的 GET 试探
+	req, err = http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err = client.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode == http.StatusPartialContent {
+		total := resp.ContentLength
+		if cr := resp.Header.Get("Content-Range"); cr != "" {
+			if idx := strings.LastIndex(cr, "/"); idx != -1 {
+				if n, err := strconv.ParseInt(cr[idx+1:], 10, 64); err == nil {
+					total = n
+				}
+			}
+		}
+		return total, true, nil
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp.ContentLength, false, nil
+	}
+	return 0, false, fmt.Errorf("探测 HTTP %d", resp.StatusCode)
+}
+
+// downloadRange 对 URL 的 [start, end] 字节区间发起一次 Range GET
+func downloadRange(ctx context.Context, client *http.Client, url string, counter *int64, start, end int64) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	cr := &countingReader{reader: resp.Body, counter: counter}
+	_, err = io.Copy(io.Discard, cr)
+	if err != nil && ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// downloadChunked 先探测 URL 是否支持 Range 请求，支持时将其切分为 chunks 片，
+// 对同一个 URL 并发发起多个 Range GET，从单个逻辑 job 内部打满链路；
+// 服务器不支持 Range（返回 200 而非 206）时自动退化为整文件下载
+func downloadChunked(ctx context.Context, client *http.Client, url string, workerID int, counter *int64, chunks int) error {
+	contentLength, acceptRanges, err := probeRange(ctx, client, url)
+	if err != nil {
+		return err
+	}
+	if !acceptRanges || contentLength <= 0 {
+		return download(ctx, client, url, workerID, counter)
+	}
+
+	chunkSize := contentLength / int64(chunks)
+	if chunkSize < 1 {
+		return download(ctx, client, url, workerID, counter)
+	}
+
+	log.Printf("  [W%d] ↓ 分片下载: %s (%d 片, 共 %s)", workerID, truncateURL(url), chunks, formatBytes(contentLength))
+
+	// 子 context：任一分片失败时取消其余分片，但不影响其他 worker
+	chunkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, chunks)
+
+	for i := 0; i < chunks; i++ {
+		start := int64(i) * chunkSize
+		end := start + chunkSize - 1
+		if i == chunks-1 {
+			end = contentLength - 1 // 最后一片吸收整除余下的尾部字节
+		}
+		wg.Add(1)
+		go func(start, end int64) {
+			defer wg.Done()
+			if err := downloadRange(chunkCtx, client, url, counter, start, end); err != nil && chunkCtx.Err() == nil {
+				errCh <- err
+				cancel()
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+	close(errCh)
+
+	if err, ok := <-errCh; ok {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
 // truncateURL 截断过长的 URL 以便日志显示
 func truncateURL(url string) string {
 	const maxLen = 60
@@ -327,12 +1219,16 @@ func truncateURL(url string) string {
 	return url[:maxLen-3] + "..."
 }
 
-// statsReporter 定期打印下载统计信息
-func statsReporter(ctx context.Context, counter *int64, startTime time.Time, limitBytes int64, balanceMode bool, iface string, offsetBytes int64) {
+// statsReporter 定期打印下载统计信息。显示的速率经 EWMA 平滑，避免
+// TCP 慢启动和突发包造成的抖动；rate 非空时还会把平滑值暴露给 autoscaleLoop，
+// activeWorkers 非空时会在状态行附加当前 worker 数（-auto 模式）
+func statsReporter(ctx context.Context, counter *int64, startTime time.Time, limitBytes int64, balanceMode bool, iface string, offsetBytes int64, rate *smoothedRate, activeWorkers func() int) {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
-	var lastBytes int64
+	// 以当前计数器为起点，避免 -resume 续传时把已累计的总量当成第一个 1s 的瞬时速率
+	lastBytes := atomic.LoadInt64(counter)
+	smoother := newEWMA(0.3)
 
 	for {
 		select {
@@ -340,17 +1236,25 @@ func statsReporter(ctx context.Context, counter *int64, startTime time.Time, lim
 			return
 		case <-ticker.C:
 			currentBytes := atomic.LoadInt64(counter)
-			speed := currentBytes - lastBytes
+			instant := currentBytes - lastBytes
 			lastBytes = currentBytes
+			smoothed := smoother.update(float64(instant))
+			if rate != nil {
+				rate.store(smoothed)
+			}
 			elapsed := time.Since(startTime)
 
-			// 构建统计行
+			// 构建统计行（EWMA 平滑后的速率）
 			line := fmt.Sprintf("\r⚡ 速率: %-12s | 累计: %-10s | 时长: %s",
-				formatSpeed(speed),
+				formatSpeed(int64(smoothed)),
 				formatBytes(currentBytes),
 				formatDuration(elapsed),
 			)
 
+			if activeWorkers != nil {
+				line += fmt.Sprintf(" | worker: %d", activeWorkers())
+			}
+
 			if balanceMode && iface != "" {
 				// 对等模式：显示实时上下行差距
 				if stats, err := getNetStats(iface); err == nil {
@@ -380,6 +1284,16 @@ type config struct {
 	iface       string
 	offsetStr   string
 	gapBytes    int64
+	chunks      int
+	autoMode    bool
+	maxWorkers  int
+	targetRate  string
+	probeMode   bool
+	minSpeedStr string
+	httpingMode bool
+	coloStr     string
+	resumed     bool
+	ctrlAddr    string
 }
 
 func printBanner(cfg *config) {
@@ -398,6 +1312,35 @@ func printBanner(cfg *config) {
 		fmt.Printf("  当前差距: %s (需下载)\n", formatBytes(cfg.gapBytes))
 	}
 	fmt.Printf("  并发数:   %d 个 worker\n", cfg.workers)
+	if cfg.chunks > 1 {
+		fmt.Printf("  分片数:   每个 URL %d 个并行 Range 请求\n", cfg.chunks)
+	}
+	if cfg.autoMode {
+		fmt.Printf("  自动扩缩: 开启 (上限 %d 个 worker", cfg.maxWorkers)
+		if cfg.targetRate != "" {
+			fmt.Printf(", 目标速率 %s", cfg.targetRate)
+		}
+		fmt.Println(")")
+	}
+	if cfg.httpingMode || cfg.coloStr != "" {
+		fmt.Println("  HTTPing:  开启 (下载前探测可达性/RTT)")
+	}
+	if cfg.coloStr != "" {
+		fmt.Printf("  POP 过滤:  仅保留 %s\n", cfg.coloStr)
+	}
+	if cfg.resumed {
+		fmt.Println("  会话续传: 已从上次的进度继续")
+	}
+	if cfg.ctrlAddr != "" {
+		fmt.Printf("  控制端点: %s\n", cfg.ctrlAddr)
+	}
+	if cfg.probeMode {
+		fmt.Print("  预检测:   开启 (按测得速度加权选择")
+		if cfg.minSpeedStr != "" && cfg.minSpeedStr != "0" {
+			fmt.Printf(", 最低 %s", cfg.minSpeedStr)
+		}
+		fmt.Println(")")
+	}
 	if !cfg.balanceMode {
 		if cfg.durationStr != "" && cfg.durationStr != "0" {
 			fmt.Printf("  运行时长: %s\n", cfg.durationStr)
@@ -430,6 +1373,17 @@ func main() {
 	balanceMode := flag.Bool("b", false, "对等模式: 自动计算上下行差距，下载至对等后停止")
 	iface := flag.String("i", "", "网卡名称 (默认自动检测，如 eth0, ens3)")
 	offsetStr := flag.String("offset", "0", "对等模式额外偏移量，即监控中已有的上下行差距 (如 1300G)")
+	chunks := flag.Int("chunks", 1, "单个 URL 的并行 Range 分片数 (仅对支持 Accept-Ranges 的服务器生效，1=不分片)")
+	autoMode := flag.Bool("auto", false, "自动扩缩容模式: 根据 EWMA 平滑速率动态增减 worker 数量")
+	maxWorkers := flag.Int("max-workers", 64, "自动扩缩容模式下 worker 数量上限")
+	targetRateStr := flag.String("target-rate", "", "自动扩缩容目标速率 (如 500M/s)，达到后停止扩容，留空表示不设目标")
+	probeMode := flag.Bool("probe", false, "预检测模式: 下载开始前对所有 URL 做吞吐量基准测试，按测得速度加权选择")
+	minSpeedStr := flag.String("min-speed", "0", "预检测模式下 URL 最低吞吐量阈值 (如 5M)，低于此值的 URL 被剔除")
+	httpingMode := flag.Bool("httping", false, "HTTPing 式健康检查: 下载开始前探测每个 URL 的状态码/RTT，剔除无法访问的 URL")
+	coloStr := flag.String("colo", "", "仅保留 HTTPing 探测命中指定 CDN POP 的 URL (逗号分隔，如 LAX,SJC,FRA)，依赖 CF-Ray 响应头；设置后自动开启 HTTPing 探测")
+	httpingTimeoutStr := flag.String("httping-timeout", "5s", "HTTPing 探测的单次请求超时")
+	resumeFlag := flag.Bool("resume", false, "从上次的会话状态续传 (读取 ~/.downtraffic/session.json)")
+	ctrlAddr := flag.String("ctrl", "", "本地控制端点地址: Unix socket 路径或 \":端口\" 形式的 TCP 地址，留空表示不启动")
 	showVersion := flag.Bool("v", false, "显示版本号")
 	flag.Parse()
 
@@ -442,6 +1396,40 @@ func main() {
 		log.Fatal("✗ 线程数必须 >= 1")
 	}
 
+	if *chunks < 1 {
+		log.Fatal("✗ 分片数必须 >= 1")
+	}
+
+	if *autoMode && *maxWorkers < *workers {
+		log.Fatal("✗ -max-workers 不能小于 -t")
+	}
+
+	targetRate, err := parseRate(*targetRateStr)
+	if err != nil {
+		log.Fatalf("✗ 无效的目标速率格式: %v", err)
+	}
+
+	minSpeedBps, err := parseSize(*minSpeedStr)
+	if err != nil {
+		log.Fatalf("✗ 无效的最低速度格式: %v", err)
+	}
+
+	httpingTimeout, err := parseDuration(*httpingTimeoutStr)
+	if err != nil {
+		log.Fatalf("✗ 无效的 httping-timeout 格式: %v", err)
+	}
+	if httpingTimeout <= 0 {
+		httpingTimeout = 5 * time.Second
+	}
+
+	allowedColos := map[string]bool{}
+	for _, c := range strings.Split(*coloStr, ",") {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if c != "" {
+			allowedColos[c] = true
+		}
+	}
+
 	// 对等模式只在 Linux 上可用
 	if *balanceMode && runtime.GOOS != "linux" {
 		log.Fatal("✗ 对等模式 (-b) 仅支持 Linux 系统")
@@ -465,8 +1453,31 @@ func main() {
 		log.Fatalf("✗ 无效的偏移量格式: %v", err)
 	}
 
+	// 会话续传：读取上次落盘的进度。非对等模式下直接复用上次的累计字节数
+	// 和流量上限；对等模式下还要结合网卡基线，补偿可能发生过的计数器归零
+	sessionPath := defaultSessionPath()
+	var resumedBytes int64
+	var resumedSession *sessionState
+	resumedStartTime := time.Now()
+	if *resumeFlag {
+		prev, loadErr := loadSession(sessionPath)
+		if loadErr != nil {
+			log.Printf("⚠ 未找到可续传的会话文件 (%s)，将重新开始: %v", sessionPath, loadErr)
+		} else {
+			resumedSession = prev
+			resumedBytes = prev.TotalBytes
+			resumedStartTime = prev.StartTime
+			if !*balanceMode && limitBytes == 0 {
+				limitBytes = prev.LimitBytes
+			}
+			log.Printf("✓ 已从会话文件续传: 此前已下载 %s，开始时间 %s",
+				formatBytes(resumedBytes), resumedStartTime.Format("2006-01-02 15:04:05"))
+		}
+	}
+
 	// 对等模式：计算需要下载的量
 	var gapBytes int64
+	var ifaceRxBaseline, ifaceTxBaseline int64
 	actualIface := *iface
 	if *balanceMode {
 		if actualIface == "" {
@@ -476,6 +1487,10 @@ func main() {
 		if err != nil {
 			log.Fatalf("✗ 读取网卡 %s 流量失败: %v", actualIface, err)
 		}
+		if resumedSession != nil {
+			offsetBytes += resumeBalanceOffset(resumedSession, stats)
+		}
+		ifaceRxBaseline, ifaceTxBaseline = stats.RxBytes, stats.TxBytes
 		// 差距 = (上行 + 额外偏移) - 下行
 		gapBytes = stats.TxBytes + offsetBytes - stats.RxBytes
 		if gapBytes <= 0 {
@@ -498,6 +1513,45 @@ func main() {
 	// 加载 URL 列表
 	urls := loadURLs(*urlFile)
 
+	// HTTPing 式健康检查：在真正下载前探测每个 URL 的状态码/RTT，剔除不可达的；
+	// -colo 非空时额外只保留服务 POP 命中的 URL（隐含开启本阶段）
+	if *httpingMode || len(allowedColos) > 0 {
+		fmt.Println("\n  📡 正在执行 HTTPing 探测...")
+		results := httpingSweep(context.Background(), urls, httpingTimeout)
+		if len(allowedColos) > 0 {
+			results = filterByColo(results, allowedColos)
+		}
+		if len(results) == 0 {
+			log.Fatal("✗ 没有 URL 通过 HTTPing 探测，无法继续")
+		}
+		printHTTPingTable(results)
+
+		httpingURLs := make([]string, len(results))
+		for i, r := range results {
+			httpingURLs[i] = r.url
+		}
+		urls = httpingURLs
+	}
+
+	// 预检测阶段：对所有 URL 做一次吞吐量基准测试，剔除不达标的，
+	// 并按测得速度构建加权选择器，供 worker 替代均匀轮询使用
+	var picker *weightedURLPicker
+	if *probeMode {
+		fmt.Println("\n  🔍 正在预检测 URL 列表...")
+		stats := benchmarkURLs(context.Background(), urls, minSpeedBps, probeSampleDuration)
+		if len(stats) == 0 {
+			log.Fatal("✗ 所有 URL 均未通过预检测，无法继续")
+		}
+		printProbeTable(stats)
+
+		probedURLs := make([]string, len(stats))
+		for i, s := range stats {
+			probedURLs[i] = s.url
+		}
+		urls = probedURLs
+		picker = newWeightedURLPicker(stats)
+	}
+
 	// 打印启动信息
 	cfg := &config{
 		workers:     *workers,
@@ -509,6 +1563,16 @@ func main() {
 		iface:       actualIface,
 		offsetStr:   *offsetStr,
 		gapBytes:    gapBytes,
+		chunks:      *chunks,
+		autoMode:    *autoMode,
+		maxWorkers:  *maxWorkers,
+		targetRate:  *targetRateStr,
+		probeMode:   *probeMode,
+		minSpeedStr: *minSpeedStr,
+		httpingMode: *httpingMode,
+		coloStr:     *coloStr,
+		resumed:     resumedSession != nil,
+		ctrlAddr:    *ctrlAddr,
 	}
 	printBanner(cfg)
 
@@ -526,12 +1590,54 @@ func main() {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
-	// 字节计数器
-	var totalBytes int64
-	startTime := time.Now()
+	// 字节计数器：-resume 时从上次落盘的进度继续累计
+	totalBytes := resumedBytes
+	startTime := resumedStartTime
 
 	// 启动统计打印协程
-	go statsReporter(ctx, &totalBytes, startTime, limitBytes, *balanceMode, actualIface, offsetBytes)
+	var rate smoothedRate
+	var activeWorkers func() int
+
+	// 所有 worker 共享同一个 http.Client/Transport，这样 MaxConnsPerHost 才是
+	// 真正的全局每主机连接数上限，而不是被 N 个 worker 各自的 Transport 乘了 N 份
+	workerCap := *workers
+	if *autoMode && *maxWorkers > workerCap {
+		workerCap = *maxWorkers
+	}
+	maxConnsPerHost := 2
+	if need := workerCap * *chunks; need > maxConnsPerHost {
+		maxConnsPerHost = need
+	}
+	client := newDownloadClient(maxConnsPerHost)
+
+	// 启动 worker 协程池（-auto 模式下由 autoscaleLoop 动态增减）
+	var wg sync.WaitGroup
+	pool := newWorkerPool(ctx, urls, &totalBytes, *chunks, &wg, picker, client)
+	ctrl := newController(pool, &totalBytes, startTime)
+	pool.ctrl = ctrl
+	for i := 0; i < *workers; i++ {
+		pool.grow()
+	}
+
+	if *autoMode {
+		activeWorkers = pool.size
+		go autoscaleLoop(ctx, pool, &rate, *maxWorkers, targetRate)
+	}
+
+	if *probeMode {
+		go probeRefreshLoop(ctx, urls, minSpeedBps, probeSampleDuration, picker)
+	}
+
+	if *ctrlAddr != "" {
+		if err := startControlServer(ctx, *ctrlAddr, ctrl); err != nil {
+			log.Printf("⚠ 控制端点启动失败: %v", err)
+		}
+	}
+
+	// 周期性落盘会话状态，供下次 -resume 续传
+	go sessionCheckpointLoop(ctx, sessionPath, &totalBytes, startTime, limitBytes, offsetBytes, ifaceRxBaseline, ifaceTxBaseline)
+
+	go statsReporter(ctx, &totalBytes, startTime, limitBytes, *balanceMode, actualIface, offsetBytes, &rate, activeWorkers)
 
 	// 流量上限检查协程（普通模式或对等模式都使用）
 	if limitBytes > 0 {
@@ -566,13 +1672,6 @@ func main() {
 		}()
 	}
 
-	// 启动 worker 协程
-	var wg sync.WaitGroup
-	for i := 0; i < *workers; i++ {
-		wg.Add(1)
-		go worker(ctx, i+1, urls, &totalBytes, &wg)
-	}
-
 	// 等待信号或完成
 	select {
 	case sig := <-sigCh: